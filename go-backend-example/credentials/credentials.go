@@ -0,0 +1,270 @@
+// Package credentials lets Servio authenticate to backend Google APIs
+// without shipping a static service-account key, by exchanging a workload
+// identity subject token for a federated access token (the external_account
+// credential type).
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the JSON shape of an external_account credential file.
+type Config struct {
+	Type             string           `json:"type"`
+	Audience         string           `json:"audience"`
+	SubjectTokenType string           `json:"subject_token_type"`
+	TokenURL         string           `json:"token_url"`
+	CredentialSource CredentialSource `json:"credential_source"`
+}
+
+// CredentialSource describes where to read the subject token from. Exactly
+// one of File, URL, or Executable should be set.
+type CredentialSource struct {
+	File       string            `json:"file,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Executable *ExecutableSource `json:"executable,omitempty"`
+}
+
+// ExecutableSource runs an external command and reads the subject token
+// from its stdout.
+type ExecutableSource struct {
+	Command       string `json:"command"`
+	TimeoutMillis int    `json:"timeout_millis,omitempty"`
+}
+
+// executableResponse is the JSON an ExecutableSource command must print to
+// stdout.
+type executableResponse struct {
+	Version        int    `json:"version"`
+	Success        bool   `json:"success"`
+	TokenType      string `json:"token_type"`
+	IDToken        string `json:"id_token,omitempty"`
+	AccessToken    string `json:"access_token,omitempty"`
+	ExpirationTime int64  `json:"expiration_time,omitempty"`
+	Code           string `json:"code,omitempty"`
+	Message        string `json:"message,omitempty"`
+}
+
+const defaultExecutableTimeout = 30 * time.Second
+
+// LoadConfig reads and parses an external_account credential file from path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if config.Type != "external_account" {
+		return nil, fmt.Errorf("credentials: unsupported type %q", config.Type)
+	}
+	return &config, nil
+}
+
+// federatedToken is the cached result of an STS token exchange.
+type federatedToken struct {
+	accessToken string
+	expiresAt   time.Time
+}
+
+// Credentials exchanges a workload identity subject token for a Google
+// federated access token, caching it until expiry.
+type Credentials struct {
+	config     *Config
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache *federatedToken
+}
+
+// New builds Credentials from a parsed external_account config.
+func New(config *Config) *Credentials {
+	return &Credentials{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// AccessToken returns a valid federated access token, refreshing it if the
+// cached one is missing or within a minute of expiry.
+func (c *Credentials) AccessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cache != nil && time.Now().Add(time.Minute).Before(c.cache.expiresAt) {
+		return c.cache.accessToken, nil
+	}
+
+	subjectToken, err := c.subjectToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("credentials: reading subject token: %w", err)
+	}
+
+	token, expiresAt, err := c.exchangeSubjectToken(ctx, subjectToken)
+	if err != nil {
+		return "", fmt.Errorf("credentials: exchanging subject token: %w", err)
+	}
+
+	c.cache = &federatedToken{accessToken: token, expiresAt: expiresAt}
+	return token, nil
+}
+
+// subjectToken reads the subject token from whichever credential source is
+// configured: a file, an HTTP URL, or a local executable.
+func (c *Credentials) subjectToken(ctx context.Context) (string, error) {
+	src := c.config.CredentialSource
+	switch {
+	case src.File != "":
+		data, err := os.ReadFile(src.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	case src.URL != "":
+		return c.subjectTokenFromURL(ctx, src)
+	case src.Executable != nil:
+		return c.subjectTokenFromExecutable(ctx, src.Executable)
+	default:
+		return "", errors.New("credentials: credential_source has no file, url, or executable set")
+	}
+}
+
+func (c *Credentials) subjectTokenFromURL(ctx context.Context, src CredentialSource) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range src.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (c *Credentials) subjectTokenFromExecutable(ctx context.Context, src *ExecutableSource) (string, error) {
+	timeout := defaultExecutableTimeout
+	if src.TimeoutMillis > 0 {
+		timeout = time.Duration(src.TimeoutMillis) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	fields := strings.Fields(src.Command)
+	if len(fields) == 0 {
+		return "", errors.New("credentials: executable command is empty")
+	}
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running executable credential source: %w", err)
+	}
+
+	var resp executableResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return "", err
+	}
+	if !resp.Success {
+		return "", fmt.Errorf("credentials: executable reported failure: %s - %s", resp.Code, resp.Message)
+	}
+	if resp.IDToken != "" {
+		return resp.IDToken, nil
+	}
+	if resp.AccessToken != "" {
+		return resp.AccessToken, nil
+	}
+	return "", errors.New("credentials: executable returned no id_token or access_token")
+}
+
+// stsTokenResponse is the STS token exchange response (RFC 8693).
+type stsTokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	Error           string `json:"error,omitempty"`
+	ErrorDesc       string `json:"error_description,omitempty"`
+}
+
+// exchangeSubjectToken trades subjectToken for a federated access token at
+// the configured STS endpoint.
+func (c *Credentials) exchangeSubjectToken(ctx context.Context, subjectToken string) (string, time.Time, error) {
+	data := url.Values{}
+	data.Set("grant_type", "urn:ietf:params:oauth:grant-type:token-exchange")
+	data.Set("audience", c.config.Audience)
+	data.Set("subject_token_type", c.config.SubjectTokenType)
+	data.Set("subject_token", subjectToken)
+	data.Set("requested_token_type", "urn:ietf:params:oauth:token-type:access_token")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	var tokenResp stsTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", time.Time{}, err
+	}
+	if tokenResp.Error != "" {
+		return "", time.Time{}, fmt.Errorf("STS error: %s - %s", tokenResp.Error, tokenResp.ErrorDesc)
+	}
+
+	return tokenResp.AccessToken, time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second), nil
+}
+
+// transport is an http.RoundTripper that injects a federated access token
+// into outbound requests.
+type transport struct {
+	creds *Credentials
+	base  http.RoundTripper
+}
+
+// RoundTripper returns an http.RoundTripper that wraps base (or
+// http.DefaultTransport if nil) and sets Authorization: Bearer on every
+// outbound request, using AccessToken to keep the token fresh.
+func (c *Credentials) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{creds: c, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.creds.AccessToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,155 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubjectTokenFromFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "subject-token")
+	if err := os.WriteFile(path, []byte("file-subject-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(&Config{CredentialSource: CredentialSource{File: path}})
+	got, err := c.subjectToken(context.Background())
+	if err != nil {
+		t.Fatalf("subjectToken: %v", err)
+	}
+	if got != "file-subject-token" {
+		t.Errorf("subjectToken = %q, want %q", got, "file-subject-token")
+	}
+}
+
+func TestSubjectTokenFromURL(t *testing.T) {
+	var gotHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Custom")
+		w.Write([]byte("url-subject-token"))
+	}))
+	defer srv.Close()
+
+	c := New(&Config{CredentialSource: CredentialSource{
+		URL:     srv.URL,
+		Headers: map[string]string{"X-Custom": "present"},
+	}})
+	got, err := c.subjectToken(context.Background())
+	if err != nil {
+		t.Fatalf("subjectToken: %v", err)
+	}
+	if got != "url-subject-token" {
+		t.Errorf("subjectToken = %q, want %q", got, "url-subject-token")
+	}
+	if gotHeader != "present" {
+		t.Errorf("configured header not sent, got %q", gotHeader)
+	}
+}
+
+func TestSubjectTokenFromExecutable(t *testing.T) {
+	c := New(&Config{CredentialSource: CredentialSource{
+		Executable: &ExecutableSource{
+			Command: `printf {"version":1,"success":true,"token_type":"urn:ietf:params:oauth:token-type:jwt","id_token":"exec-subject-token"}`,
+		},
+	}})
+	got, err := c.subjectToken(context.Background())
+	if err != nil {
+		t.Fatalf("subjectToken: %v", err)
+	}
+	if got != "exec-subject-token" {
+		t.Errorf("subjectToken = %q, want %q", got, "exec-subject-token")
+	}
+}
+
+func TestSubjectTokenFromExecutable_Failure(t *testing.T) {
+	c := New(&Config{CredentialSource: CredentialSource{
+		Executable: &ExecutableSource{
+			Command: `printf {"version":1,"success":false,"code":"denied","message":"nope"}`,
+		},
+	}})
+	if _, err := c.subjectToken(context.Background()); err == nil {
+		t.Fatal("expected error for success=false executable response")
+	}
+}
+
+func TestAccessTokenExchangesAndCaches(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "subject-token")
+	if err := os.WriteFile(path, []byte("file-subject-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	exchanges := 0
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exchanges++
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.FormValue("grant_type"); got != "urn:ietf:params:oauth:grant-type:token-exchange" {
+			t.Errorf("grant_type = %q", got)
+		}
+		if got := r.FormValue("subject_token"); got != "file-subject-token" {
+			t.Errorf("subject_token = %q", got)
+		}
+		json.NewEncoder(w).Encode(stsTokenResponse{
+			AccessToken: "federated-access-token",
+			ExpiresIn:   3600,
+		})
+	}))
+	defer sts.Close()
+
+	c := New(&Config{
+		Audience:         "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider",
+		SubjectTokenType: "urn:ietf:params:oauth:token-type:jwt",
+		TokenURL:         sts.URL,
+		CredentialSource: CredentialSource{File: path},
+	})
+
+	token, err := c.AccessToken(context.Background())
+	if err != nil {
+		t.Fatalf("AccessToken: %v", err)
+	}
+	if token != "federated-access-token" {
+		t.Errorf("AccessToken = %q, want %q", token, "federated-access-token")
+	}
+	if exchanges != 1 {
+		t.Fatalf("exchanges = %d, want 1", exchanges)
+	}
+
+	// A second call before expiry should be served from cache, not hit the
+	// STS endpoint again.
+	if _, err := c.AccessToken(context.Background()); err != nil {
+		t.Fatalf("AccessToken (cached): %v", err)
+	}
+	if exchanges != 1 {
+		t.Errorf("exchanges = %d after cached call, want 1", exchanges)
+	}
+}
+
+func TestRoundTripperInjectsAuthorizationHeader(t *testing.T) {
+	var gotAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	}))
+	defer upstream.Close()
+
+	c := New(&Config{})
+	c.cache = &federatedToken{
+		accessToken: "cached-token",
+		expiresAt:   time.Now().Add(time.Hour),
+	}
+
+	client := &http.Client{Transport: c.RoundTripper(nil)}
+	if _, err := client.Get(upstream.URL); err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	if gotAuth != "Bearer cached-token" {
+		t.Errorf("Authorization header = %q, want %q", gotAuth, "Bearer cached-token")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceStartResponse is what /device/start hands back to the client so it
+// can display the user_code and verification_uri.
+type DeviceStartResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// DevicePollParams is the flat JSON the client sends to poll for a token.
+type DevicePollParams struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// deviceAuthResponse mirrors the provider's device authorization endpoint
+// response (RFC 8628 section 3.2).
+type deviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// deviceSession tracks the server-side polling state for one in-flight
+// device code so we can enforce the provider's poll interval and expiry.
+type deviceSession struct {
+	provider     string
+	config       *OAuthConfig
+	interval     time.Duration
+	expiresAt    time.Time
+	lastPolledAt time.Time
+}
+
+var deviceSessionsMu sync.Mutex
+var deviceSessions = map[string]*deviceSession{}
+
+const defaultDevicePollInterval = 5 * time.Second
+
+// deviceSessionReapInterval governs how often abandoned device sessions
+// (started but never polled to completion or expiry) are swept out.
+const deviceSessionReapInterval = 5 * time.Minute
+
+func init() {
+	go reapExpiredDeviceSessions()
+}
+
+// reapExpiredDeviceSessions periodically clears out device sessions nobody
+// ever polled to completion (user never scanned the code, CLI was killed,
+// TV powered off), so the map doesn't grow without bound. Mirrors
+// reapExpiredPKCESessions in pkce.go.
+func reapExpiredDeviceSessions() {
+	ticker := time.NewTicker(deviceSessionReapInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sweepExpiredDeviceSessions(time.Now())
+	}
+}
+
+// sweepExpiredDeviceSessions deletes every deviceSession that expired
+// before now.
+func sweepExpiredDeviceSessions(now time.Time) {
+	deviceSessionsMu.Lock()
+	defer deviceSessionsMu.Unlock()
+	for code, session := range deviceSessions {
+		if now.After(session.expiresAt) {
+			delete(deviceSessions, code)
+		}
+	}
+}
+
+// handleDeviceStart calls the provider's device authorization endpoint and
+// returns the user-facing fields the client needs to start polling.
+func handleDeviceStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	providerName, ok := providerFromPath(r.URL.Path, "/device/start")
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	provider, ok := providers.Get(providerName)
+	if !ok || provider.DeviceAuthURL() == "" {
+		log.Printf("[AUTH LOG] device flow not supported for provider=%s", providerName)
+		http.Error(w, "Device flow not supported for this provider", http.StatusNotFound)
+		return
+	}
+
+	config := getOAuthConfig(providerName)
+	if config.ClientID == "" {
+		log.Printf("[AUTH LOG] Missing %s_OAUTH_CLIENT_ID configuration", strings.ToUpper(providerName))
+		http.Error(w, "Server configuration error", http.StatusInternalServerError)
+		return
+	}
+
+	data := url.Values{}
+	data.Set("client_id", config.ClientID)
+	if scopes := provider.Scopes(); len(scopes) > 0 {
+		data.Set("scope", strings.Join(scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", provider.DeviceAuthURL(), strings.NewReader(data.Encode()))
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to create device authorization request: %v", err)
+		http.Error(w, "Device authorization failed", http.StatusBadGateway)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[AUTH LOG] Device authorization request failed: %v", err)
+		http.Error(w, "Device authorization failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	var authResp deviceAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		log.Printf("[AUTH LOG] Failed to decode device authorization response: %v", err)
+		http.Error(w, "Device authorization failed", http.StatusBadGateway)
+		return
+	}
+
+	interval := authResp.Interval
+	if interval <= 0 {
+		interval = int(defaultDevicePollInterval / time.Second)
+	}
+
+	deviceSessionsMu.Lock()
+	deviceSessions[authResp.DeviceCode] = &deviceSession{
+		provider:  providerName,
+		config:    config,
+		interval:  time.Duration(interval) * time.Second,
+		expiresAt: time.Now().Add(time.Duration(authResp.ExpiresIn) * time.Second),
+	}
+	deviceSessionsMu.Unlock()
+
+	log.Printf("[AUTH LOG] device_start provider=%s user_code=%s", providerName, authResp.UserCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceStartResponse{
+		DeviceCode:      authResp.DeviceCode,
+		UserCode:        authResp.UserCode,
+		VerificationURI: authResp.VerificationURI,
+		ExpiresIn:       authResp.ExpiresIn,
+		Interval:        interval,
+	})
+}
+
+// handleDevicePoll exchanges a device_code at the provider's token endpoint
+// using grant_type=urn:ietf:params:oauth:grant-type:device_code, translating
+// the RFC 8628 error codes into the responses the frontend expects.
+func handleDevicePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	providerName, ok := providerFromPath(r.URL.Path, "/device/poll")
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	provider, ok := providers.Get(providerName)
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	var params DevicePollParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	deviceSessionsMu.Lock()
+	session, ok := deviceSessions[params.DeviceCode]
+	deviceSessionsMu.Unlock()
+	if !ok {
+		writeDeviceError(w, "expired_token")
+		return
+	}
+
+	if time.Now().After(session.expiresAt) {
+		deviceSessionsMu.Lock()
+		delete(deviceSessions, params.DeviceCode)
+		deviceSessionsMu.Unlock()
+		writeDeviceError(w, "expired_token")
+		return
+	}
+
+	deviceSessionsMu.Lock()
+	if time.Since(session.lastPolledAt) < session.interval {
+		deviceSessionsMu.Unlock()
+		writeDeviceError(w, "authorization_pending")
+		return
+	}
+	session.lastPolledAt = time.Now()
+	deviceSessionsMu.Unlock()
+
+	data := url.Values{}
+	data.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	data.Set("device_code", params.DeviceCode)
+	data.Set("client_id", session.config.ClientID)
+	if session.config.ClientSecret != "" {
+		data.Set("client_secret", session.config.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", provider.TokenURL(), strings.NewReader(data.Encode()))
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to create device poll request: %v", err)
+		http.Error(w, "Token exchange failed", http.StatusBadGateway)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[AUTH LOG] Device poll request failed: %v", err)
+		http.Error(w, "Token exchange failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tokenResp GoogleTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		log.Printf("[AUTH LOG] Failed to decode device poll response: %v", err)
+		http.Error(w, "Token exchange failed", http.StatusBadGateway)
+		return
+	}
+
+	switch tokenResp.Error {
+	case "":
+		deviceSessionsMu.Lock()
+		delete(deviceSessions, params.DeviceCode)
+		deviceSessionsMu.Unlock()
+		log.Printf("[AUTH LOG] device_poll provider=%s success", providerName)
+		finishLogin(w, r, providerName, provider, session.config, &tokenResp, "")
+	case "slow_down":
+		deviceSessionsMu.Lock()
+		session.interval += 5 * time.Second
+		deviceSessionsMu.Unlock()
+		writeDeviceError(w, "slow_down")
+	case "authorization_pending":
+		writeDeviceError(w, "authorization_pending")
+	case "access_denied":
+		deviceSessionsMu.Lock()
+		delete(deviceSessions, params.DeviceCode)
+		deviceSessionsMu.Unlock()
+		writeDeviceError(w, "access_denied")
+	case "expired_token":
+		deviceSessionsMu.Lock()
+		delete(deviceSessions, params.DeviceCode)
+		deviceSessionsMu.Unlock()
+		writeDeviceError(w, "expired_token")
+	default:
+		log.Printf("[AUTH LOG] device_poll provider=%s unexpected error=%s", providerName, tokenResp.Error)
+		writeDeviceError(w, tokenResp.Error)
+	}
+}
+
+// writeDeviceError returns an RFC 8628 style error as JSON rather than a
+// plain http.Error body, since the client polls this endpoint in a loop and
+// needs to branch on the error code.
+func writeDeviceError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSweepExpiredDeviceSessions(t *testing.T) {
+	deviceSessionsMu.Lock()
+	deviceSessions = map[string]*deviceSession{
+		"expired": {expiresAt: time.Now().Add(-time.Minute)},
+		"fresh":   {expiresAt: time.Now().Add(time.Hour)},
+	}
+	deviceSessionsMu.Unlock()
+
+	sweepExpiredDeviceSessions(time.Now())
+
+	deviceSessionsMu.Lock()
+	defer deviceSessionsMu.Unlock()
+	if _, ok := deviceSessions["expired"]; ok {
+		t.Error("expired session was not swept")
+	}
+	if _, ok := deviceSessions["fresh"]; !ok {
+		t.Error("fresh session was incorrectly swept")
+	}
+}
+
+// TestHandleDevicePoll_ErrorCodeTranslation drives handleDevicePoll against
+// a fake token endpoint returning each RFC 8628 error code and checks the
+// JSON body we translate it to.
+func TestHandleDevicePoll_ErrorCodeTranslation(t *testing.T) {
+	var tokenResponse map[string]string
+	var requests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		json.NewEncoder(w).Encode(tokenResponse)
+	}))
+	defer tokenServer.Close()
+
+	providers.register(&oauth2Provider{name: "testdevice", tokenURL: tokenServer.URL})
+
+	tests := []struct {
+		name          string
+		providerError string
+		wantBody      string
+		wantDeleted   bool
+	}{
+		{"authorization_pending", "authorization_pending", `{"error":"authorization_pending"}`, false},
+		{"slow_down", "slow_down", `{"error":"slow_down"}`, false},
+		{"access_denied", "access_denied", `{"error":"access_denied"}`, true},
+		{"expired_token", "expired_token", `{"error":"expired_token"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenResponse = map[string]string{"error": tt.providerError}
+
+			deviceCode := "code-" + tt.name
+			session := &deviceSession{
+				provider:  "testdevice",
+				config:    &OAuthConfig{ClientID: "client"},
+				interval:  0,
+				expiresAt: time.Now().Add(time.Hour),
+			}
+			deviceSessionsMu.Lock()
+			deviceSessions[deviceCode] = session
+			deviceSessionsMu.Unlock()
+
+			body, _ := json.Marshal(DevicePollParams{DeviceCode: deviceCode})
+			req := httptest.NewRequest(http.MethodPost, "/api/auth/testdevice/device/poll", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+
+			handleDevicePoll(w, req)
+
+			got := strings.TrimSpace(w.Body.String())
+			if got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+
+			deviceSessionsMu.Lock()
+			_, stillPresent := deviceSessions[deviceCode]
+			deviceSessionsMu.Unlock()
+			if tt.wantDeleted && stillPresent {
+				t.Errorf("expected session to be deleted after %s", tt.providerError)
+			}
+			if !tt.wantDeleted && !stillPresent {
+				t.Errorf("expected session to survive %s", tt.providerError)
+			}
+		})
+	}
+
+	// slow_down must back off the interval the RFC-mandated 5 seconds.
+	deviceSessionsMu.Lock()
+	slowDownSession := deviceSessions["code-slow_down"]
+	deviceSessionsMu.Unlock()
+	if slowDownSession == nil || slowDownSession.interval != 5*time.Second {
+		t.Errorf("slow_down interval = %v, want 5s", slowDownSession)
+	}
+}
+
+// TestHandleDevicePoll_TooSoonDoesNotHitProvider verifies that polling
+// before the stored interval has elapsed returns authorization_pending
+// locally, without consulting the provider's token endpoint.
+func TestHandleDevicePoll_TooSoonDoesNotHitProvider(t *testing.T) {
+	var requests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		json.NewEncoder(w).Encode(map[string]string{})
+	}))
+	defer tokenServer.Close()
+
+	providers.register(&oauth2Provider{name: "testdevice-toosoon", tokenURL: tokenServer.URL})
+
+	deviceCode := "code-too-soon"
+	deviceSessionsMu.Lock()
+	deviceSessions[deviceCode] = &deviceSession{
+		provider:     "testdevice-toosoon",
+		config:       &OAuthConfig{ClientID: "client"},
+		interval:     time.Minute,
+		lastPolledAt: time.Now(),
+		expiresAt:    time.Now().Add(time.Hour),
+	}
+	deviceSessionsMu.Unlock()
+
+	body, _ := json.Marshal(DevicePollParams{DeviceCode: deviceCode})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/testdevice-toosoon/device/poll", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handleDevicePoll(w, req)
+
+	if got := strings.TrimSpace(w.Body.String()); got != `{"error":"authorization_pending"}` {
+		t.Errorf("body = %q, want authorization_pending", got)
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Errorf("expected no requests to the provider's token endpoint, got %d", requests)
+	}
+}
+
+func TestHandleDevicePoll_ExpiredSession(t *testing.T) {
+	deviceCode := "code-expired-session"
+	deviceSessionsMu.Lock()
+	deviceSessions[deviceCode] = &deviceSession{
+		provider:  "testdevice",
+		expiresAt: time.Now().Add(-time.Minute),
+	}
+	deviceSessionsMu.Unlock()
+
+	body, _ := json.Marshal(DevicePollParams{DeviceCode: deviceCode})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/testdevice/device/poll", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handleDevicePoll(w, req)
+
+	if got := strings.TrimSpace(w.Body.String()); got != `{"error":"expired_token"}` {
+		t.Errorf("body = %q, want expired_token", got)
+	}
+	deviceSessionsMu.Lock()
+	_, stillPresent := deviceSessions[deviceCode]
+	deviceSessionsMu.Unlock()
+	if stillPresent {
+		t.Error("expired session should have been deleted")
+	}
+}
+
+func TestHandleDevicePoll_UnknownDeviceCode(t *testing.T) {
+	body, _ := json.Marshal(DevicePollParams{DeviceCode: "never-issued"})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/testdevice/device/poll", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handleDevicePoll(w, req)
+
+	if got := strings.TrimSpace(w.Body.String()); got != `{"error":"expired_token"}` {
+		t.Errorf("body = %q, want expired_token", got)
+	}
+}
@@ -0,0 +1,284 @@
+// Package idtoken verifies OpenID Connect id_tokens against a provider's
+// published JWKS, so the auth backend no longer hands an unverified JWT
+// straight to the browser.
+package idtoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Claims holds the subset of standard OIDC claims Servio cares about, plus
+// the full decoded payload for callers that need something provider-specific.
+type Claims struct {
+	Issuer    string                 `json:"iss"`
+	Subject   string                 `json:"sub"`
+	Audience  string                 `json:"aud"`
+	Email     string                 `json:"email"`
+	Nonce     string                 `json:"nonce"`
+	ExpiresAt int64                  `json:"exp"`
+	IssuedAt  int64                  `json:"iat"`
+	Raw       map[string]interface{} `json:"-"`
+}
+
+// Options configures a single ValidateIDToken call.
+type Options struct {
+	// JWKSURL is the provider's JSON Web Key Set endpoint.
+	JWKSURL string
+	// Issuers lists the acceptable `iss` values for this provider.
+	Issuers []string
+	// Audience must equal the token's `aud` claim (the OAuth client_id).
+	Audience string
+	// Nonce, if non-empty, must equal the token's `nonce` claim.
+	Nonce string
+}
+
+var (
+	// ErrInvalidSignature is returned when the JWT's RS256 signature does
+	// not verify against the provider's published keys.
+	ErrInvalidSignature = errors.New("idtoken: invalid signature")
+	// ErrExpired is returned when the token's `exp` claim is in the past.
+	ErrExpired = errors.New("idtoken: token expired")
+	// ErrIssuedAtInvalid is returned when the token's `iat` claim is in the
+	// future (beyond clock skew tolerance) or older than maxIDTokenAge.
+	ErrIssuedAtInvalid = errors.New("idtoken: iat claim invalid")
+)
+
+// clockSkewAllowance tolerates modest clock drift between Servio and the
+// provider when checking `iat`.
+const clockSkewAllowance = 5 * time.Minute
+
+// maxIDTokenAge rejects id_tokens minted long before they were presented,
+// which would indicate a stale or replayed token.
+const maxIDTokenAge = 24 * time.Hour
+
+// DefaultCache is the shared JWKS cache used by ValidateIDToken. Additional
+// providers' key sets can be registered by URL without code changes.
+var DefaultCache = NewJWKSCache()
+
+// jwk is a single entry from a JSON Web Key Set.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksResponse struct {
+	Keys []jwk `json:"keys"`
+}
+
+type cachedKeySet struct {
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+}
+
+// JWKSCache fetches and caches JSON Web Key Sets, honoring the
+// Cache-Control: max-age header on the JWKS response.
+type JWKSCache struct {
+	mu         sync.Mutex
+	sets       map[string]*cachedKeySet
+	httpClient *http.Client
+}
+
+// NewJWKSCache returns an empty JWKSCache.
+func NewJWKSCache() *JWKSCache {
+	return &JWKSCache{
+		sets:       make(map[string]*cachedKeySet),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// key returns the RSA public key for kid from the JWKS at url, refetching
+// if the cached set is missing, expired, or doesn't contain kid.
+func (c *JWKSCache) key(ctx context.Context, url, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	set, ok := c.sets[url]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(set.expiresAt) {
+		if key, found := set.keys[kid]; found {
+			return key, nil
+		}
+	}
+
+	set, err := c.fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.sets[url] = set
+	c.mu.Unlock()
+
+	key, found := set.keys[kid]
+	if !found {
+		return nil, fmt.Errorf("idtoken: kid %q not found in %s", kid, url)
+	}
+	return key, nil
+}
+
+func (c *JWKSCache) fetch(ctx context.Context, url string) (*cachedKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var parsed jwksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(parsed.Keys))
+	for _, k := range parsed.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := rsaPublicKeyFromJWK(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	return &cachedKeySet{keys: keys, expiresAt: time.Now().Add(maxAge(resp.Header.Get("Cache-Control")))}, nil
+}
+
+// maxAge parses the max-age directive from a Cache-Control header, falling
+// back to a conservative default when absent or malformed.
+func maxAge(header string) time.Duration {
+	const fallback = 5 * time.Minute
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil || seconds <= 0 {
+			return fallback
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+func rsaPublicKeyFromJWK(k jwk) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+// ValidateIDToken parses raw as a JWT, verifies its RS256 signature against
+// the JWKS at opts.JWKSURL (fetched through cache), and checks iss, aud,
+// exp, iat, and nonce (when opts.Nonce is set).
+func ValidateIDToken(ctx context.Context, raw string, opts Options) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("idtoken: malformed JWT")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("idtoken: unsupported alg %q", header.Alg)
+	}
+
+	pub, err := DefaultCache.key(ctx, opts.JWKSURL, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := parts[0] + "." + parts[1]
+	digest := sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	var raw2 map[string]interface{}
+	if err := json.Unmarshal(payloadJSON, &raw2); err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return nil, err
+	}
+	claims.Raw = raw2
+
+	if !issuerAllowed(claims.Issuer, opts.Issuers) {
+		return nil, fmt.Errorf("idtoken: unexpected issuer %q", claims.Issuer)
+	}
+	if claims.Audience != opts.Audience {
+		return nil, fmt.Errorf("idtoken: unexpected audience %q", claims.Audience)
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	if claims.IssuedAt != 0 {
+		iat := time.Unix(claims.IssuedAt, 0)
+		now := time.Now()
+		if iat.After(now.Add(clockSkewAllowance)) || now.Sub(iat) > maxIDTokenAge {
+			return nil, ErrIssuedAtInvalid
+		}
+	}
+	if opts.Nonce != "" && claims.Nonce != opts.Nonce {
+		return nil, errors.New("idtoken: nonce mismatch")
+	}
+
+	return &claims, nil
+}
+
+func issuerAllowed(iss string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if iss == a {
+			return true
+		}
+	}
+	return false
+}
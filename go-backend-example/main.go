@@ -3,21 +3,40 @@ package main
 import (
 	"log"
 	"net/http"
+	"strings"
 )
 
-// PKCEGrantParams matches the flat JSON structure sent by the frontend
-// Frontend sends: { "auth_code": "AUTH_CODE_FROM_GOOGLE", "verifier": "PKCE_VERIFIER_STRING" }
+// PKCEGrantParams matches the flat JSON structure sent by the frontend.
+// Frontend sends: { "auth_code": "AUTH_CODE_FROM_GOOGLE", "state": "STATE_FROM_START" }
+// The PKCE verifier and nonce are never sent by the frontend; they're held
+// server-side in the oauth_session from /api/auth/{provider}/start.
 type PKCEGrantParams struct {
 	AuthCode string `json:"auth_code"`
-	Verifier string `json:"verifier"`
+	State    string `json:"state"`
 }
 
 func main() {
-	http.HandleFunc("/api/auth/google/callback", handleOAuthCallback)
-	
+	http.HandleFunc("/api/auth/session", handleSession)
+	http.HandleFunc("/api/auth/refresh", handleRefresh)
+	http.HandleFunc("/api/auth/logout", handleLogout)
+	http.HandleFunc("/api/auth/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/callback"):
+			handleOAuthCallback(w, r)
+		case strings.HasSuffix(r.URL.Path, "/device/start"):
+			handleDeviceStart(w, r)
+		case strings.HasSuffix(r.URL.Path, "/device/poll"):
+			handleDevicePoll(w, r)
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			handleAuthStart(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
 	port := ":8080"
 	log.Printf("[AUTH LOG] Starting Go OAuth backend on port %s", port)
-	log.Printf("[AUTH LOG] Ready to receive flat JSON structure: { \"code\": \"...\", \"verifier\": \"...\" }")
+	log.Printf("[AUTH LOG] Routing /api/auth/{provider}/{start,callback,device/start,device/poll} across %d registered providers", len(providers.providers))
 	
 	if err := http.ListenAndServe(port, nil); err != nil {
 		log.Fatal(err)
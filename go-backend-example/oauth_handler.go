@@ -2,23 +2,26 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/amaan667/Servio/go-backend-example/idtoken"
 )
 
-// OAuthConfig holds the OAuth configuration
+// OAuthConfig holds the OAuth configuration for a single provider
 type OAuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURI  string
 }
 
-// GoogleTokenResponse represents the response from Google's token endpoint
+// GoogleTokenResponse represents the token endpoint response shape. It is
+// reused across providers since every oauth2Provider exchange returns the
+// same fields, regardless of which service issued them.
 type GoogleTokenResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token,omitempty"`
@@ -30,69 +33,106 @@ type GoogleTokenResponse struct {
 	ErrorDesc    string `json:"error_description,omitempty"`
 }
 
-// getOAuthConfig loads OAuth configuration from environment variables
-func getOAuthConfig() *OAuthConfig {
-	return &OAuthConfig{
-		ClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
-		RedirectURI:  os.Getenv("GOOGLE_OAUTH_REDIRECT_URI"),
-	}
+// providers is the process-wide registry of configured OAuth providers.
+var providers = NewProviderRegistry()
+
+// oauthFileConfig is the on-disk shape of one provider's entry in the
+// optional OAUTH_CONFIG_FILE, e.g. {"google": {"client_id": "...", ...}}.
+type oauthFileConfig struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURI  string `json:"redirect_uri"`
 }
 
-// exchangeCodeForToken performs the actual OAuth token exchange with Google
-func exchangeCodeForToken(code, verifier string, config *OAuthConfig) (*GoogleTokenResponse, error) {
-	log.Printf("[AUTH LOG] Starting token exchange with Google")
+var (
+	oauthFileConfigOnce sync.Once
+	oauthFileConfigs    map[string]oauthFileConfig
+)
 
-	// Prepare the token exchange request
-	data := url.Values{}
-	data.Set("grant_type", "authorization_code")
-	data.Set("code", code)
-	data.Set("redirect_uri", config.RedirectURI)
-	data.Set("client_id", config.ClientID)
-	data.Set("code_verifier", verifier)
-	if config.ClientSecret != "" {
-		data.Set("client_secret", config.ClientSecret)
-	}
+// loadOAuthConfigFile reads and parses the JSON file named by
+// OAUTH_CONFIG_FILE, if set. A missing env var is not an error; the file is
+// only a fallback for fields env vars don't cover.
+func loadOAuthConfigFile() map[string]oauthFileConfig {
+	oauthFileConfigOnce.Do(func() {
+		path := os.Getenv("OAUTH_CONFIG_FILE")
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("[AUTH LOG] failed to read OAUTH_CONFIG_FILE=%s: %v", path, err)
+			return
+		}
+		var configs map[string]oauthFileConfig
+		if err := json.Unmarshal(data, &configs); err != nil {
+			log.Printf("[AUTH LOG] failed to parse OAUTH_CONFIG_FILE=%s: %v", path, err)
+			return
+		}
+		oauthFileConfigs = configs
+	})
+	return oauthFileConfigs
+}
 
-	// Make the request to Google's token endpoint
-	req, err := http.NewRequest("POST", "https://oauth2.googleapis.com/token", strings.NewReader(data.Encode()))
-	if err != nil {
-		log.Printf("[AUTH LOG] Failed to create token request: %v", err)
-		return nil, err
+// getOAuthConfig loads OAuth configuration for the named provider from
+// environment variables, e.g. GOOGLE_OAUTH_CLIENT_ID, GITHUB_OAUTH_CLIENT_ID.
+// Any field an env var leaves empty falls back to that provider's entry in
+// OAUTH_CONFIG_FILE, if one is configured.
+func getOAuthConfig(provider string) *OAuthConfig {
+	prefix := strings.ToUpper(provider) + "_OAUTH_"
+	config := &OAuthConfig{
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURI:  os.Getenv(prefix + "REDIRECT_URI"),
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("[AUTH LOG] Failed to make token request: %v", err)
-		return nil, err
+	fromFile := loadOAuthConfigFile()[provider]
+	if config.ClientID == "" {
+		config.ClientID = fromFile.ClientID
 	}
-	defer resp.Body.Close()
-
-	var tokenResp GoogleTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		log.Printf("[AUTH LOG] Failed to decode token response: %v", err)
-		return nil, err
+	if config.ClientSecret == "" {
+		config.ClientSecret = fromFile.ClientSecret
 	}
-
-	if tokenResp.Error != "" {
-		log.Printf("[AUTH LOG] Google OAuth error: %s - %s", tokenResp.Error, tokenResp.ErrorDesc)
-		return &tokenResp, fmt.Errorf("OAuth error: %s", tokenResp.Error)
+	if config.RedirectURI == "" {
+		config.RedirectURI = fromFile.RedirectURI
 	}
+	return config
+}
 
-	log.Printf("[AUTH LOG] Token exchange successful, access_token present: %t", tokenResp.AccessToken != "")
-	return &tokenResp, nil
+// providerFromPath extracts the provider name from a
+// /api/auth/{provider}/callback style path.
+func providerFromPath(path, suffix string) (string, bool) {
+	trimmed := strings.TrimPrefix(path, "/api/auth/")
+	if trimmed == path {
+		return "", false
+	}
+	trimmed = strings.TrimSuffix(trimmed, suffix)
+	if trimmed == "" || strings.Contains(trimmed, "/") {
+		return "", false
+	}
+	return trimmed, true
 }
 
-// handleOAuthCallback processes the OAuth callback from the frontend
+// handleOAuthCallback processes the OAuth callback from the frontend for
+// any registered provider.
 func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	providerName, ok := providerFromPath(r.URL.Path, "/callback")
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	provider, ok := providers.Get(providerName)
+	if !ok {
+		log.Printf("[AUTH LOG] unknown_provider=%s", providerName)
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
 	// Parse the flat JSON structure from frontend
 	var params PKCEGrantParams
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
@@ -101,8 +141,7 @@ func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Comprehensive logging as requested
-	log.Printf("[AUTH LOG] received_code=%s, received_verifier=%s", params.AuthCode, params.Verifier)
+	log.Printf("[AUTH LOG] provider=%s, received_code=%s, received_state=%s", providerName, params.AuthCode, params.State)
 
 	if params.AuthCode == "" {
 		log.Printf("[AUTH LOG] missing_code")
@@ -110,35 +149,93 @@ func handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if params.Verifier == "" {
-		log.Printf("[AUTH LOG] missing_verifier")
-		http.Error(w, "missing_verifier", http.StatusBadRequest)
+	if params.State == "" {
+		log.Printf("[AUTH LOG] missing_state")
+		http.Error(w, "missing_state", http.StatusBadRequest)
+		return
+	}
+
+	// Look up the session this browser started with via /start: it holds
+	// the expected state and the PKCE verifier the frontend never saw.
+	pkce, err := consumePKCESession(r, providerName)
+	if err != nil {
+		log.Printf("[AUTH LOG] pkce session lookup failed provider=%s: %v", providerName, err)
+		http.Error(w, "Invalid or expired session", http.StatusBadRequest)
+		return
+	}
+	if params.State != pkce.state {
+		log.Printf("[AUTH LOG] state mismatch provider=%s", providerName)
+		http.Error(w, "State mismatch", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[AUTH LOG] Processing OAuth exchange for code length=%d, verifier length=%d", 
-		len(params.AuthCode), len(params.Verifier))
+	log.Printf("[AUTH LOG] Processing OAuth exchange for provider=%s code length=%d",
+		providerName, len(params.AuthCode))
 
 	// Get OAuth configuration
-	config := getOAuthConfig()
+	config := getOAuthConfig(providerName)
 	if config.ClientID == "" {
-		log.Printf("[AUTH LOG] Missing GOOGLE_OAUTH_CLIENT_ID configuration")
+		log.Printf("[AUTH LOG] Missing %s_OAUTH_CLIENT_ID configuration", strings.ToUpper(providerName))
 		http.Error(w, "Server configuration error", http.StatusInternalServerError)
 		return
 	}
 
-	// Exchange the authorization code for tokens
-	tokenResp, err := exchangeCodeForToken(params.AuthCode, params.Verifier, config)
+	// Exchange the authorization code for tokens, using the verifier we
+	// generated and held server-side in /start.
+	tokenResp, err := provider.ExchangeCode(params.AuthCode, pkce.codeVerifier, config)
 	if err != nil {
 		log.Printf("[AUTH LOG] Token exchange failed: %v", err)
 		http.Error(w, "Token exchange failed", http.StatusBadRequest)
 		return
 	}
 
-	// Log successful exchange
-	log.Printf("[AUTH LOG] OAuth exchange completed successfully")
+	log.Printf("[AUTH LOG] OAuth exchange completed successfully for provider=%s", providerName)
+
+	finishLogin(w, r, providerName, provider, config, tokenResp, pkce.nonce)
+}
+
+// finishLogin validates the id_token (when the provider publishes a JWKS),
+// persists the token material server-side, and hands the browser back an
+// opaque session cookie instead of the raw tokens. Shared by every login
+// path that ends with a GoogleTokenResponse: the PKCE callback and the
+// device flow poll.
+func finishLogin(w http.ResponseWriter, r *http.Request, providerName string, provider Provider, config *OAuthConfig, tokenResp *GoogleTokenResponse, nonce string) {
+	if tokenResp.IDToken != "" && provider.JWKSURL() != "" {
+		_, err := idtoken.ValidateIDToken(r.Context(), tokenResp.IDToken, idtoken.Options{
+			JWKSURL:  provider.JWKSURL(),
+			Issuers:  provider.Issuers(),
+			Audience: config.ClientID,
+			Nonce:    nonce,
+		})
+		if err != nil {
+			log.Printf("[AUTH LOG] id_token validation failed for provider=%s: %v", providerName, err)
+			http.Error(w, "Token validation failed", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Persist the token material server-side and hand the browser back only
+	// an opaque session ID, rather than the raw tokens.
+	sessionID, err := newSessionID()
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to generate session ID: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	stored := &StoredToken{
+		Provider:     providerName,
+		AccessToken:  tokenResp.AccessToken,
+		RefreshToken: tokenResp.RefreshToken,
+		IDToken:      tokenResp.IDToken,
+		ExpiresAt:    time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
+	}
+	if err := tokenStore.Save(sessionID, stored); err != nil {
+		log.Printf("[AUTH LOG] Failed to persist session: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, sessionID)
 
-	// Return the token response to the client
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(tokenResp)
+	json.NewEncoder(w).Encode(map[string]bool{"authenticated": true})
 }
\ No newline at end of file
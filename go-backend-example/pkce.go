@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	errNoPKCESession      = errors.New("no PKCE session for this request")
+	errExpiredPKCESession = errors.New("PKCE session expired")
+	errProviderMismatch   = errors.New("PKCE session belongs to a different provider")
+)
+
+const oauthSessionCookieName = "oauth_session"
+const pkceSessionTTL = 10 * time.Minute
+
+// pkceSession is the server-held state for one in-flight login: the state
+// parameter and PKCE verifier the frontend never sees, plus the nonce we'll
+// check against the returned id_token.
+type pkceSession struct {
+	provider     string
+	state        string
+	codeVerifier string
+	nonce        string
+	expiresAt    time.Time
+}
+
+var pkceSessionsMu sync.Mutex
+var pkceSessions = map[string]*pkceSession{}
+
+func init() {
+	go reapExpiredPKCESessions()
+}
+
+// reapExpiredPKCESessions periodically clears out sessions nobody ever came
+// back to redeem (abandoned logins), so the map doesn't grow without bound.
+func reapExpiredPKCESessions() {
+	ticker := time.NewTicker(pkceSessionTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		sweepExpiredPKCESessions(time.Now())
+	}
+}
+
+// sweepExpiredPKCESessions deletes every pkceSession that expired before now.
+func sweepExpiredPKCESessions(now time.Time) {
+	pkceSessionsMu.Lock()
+	defer pkceSessionsMu.Unlock()
+	for id, session := range pkceSessions {
+		if now.After(session.expiresAt) {
+			delete(pkceSessions, id)
+		}
+	}
+}
+
+// AuthStartResponse is returned to the frontend so it can build the
+// provider's authorization redirect URL.
+type AuthStartResponse struct {
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	// Nonce must be passed through unchanged as the `nonce` parameter on
+	// the provider's authorize redirect, so the returned id_token echoes
+	// it back for handleOAuthCallback to check.
+	Nonce string `json:"nonce"`
+}
+
+// randomURLSafeString returns a base64url-encoded random string from n
+// random bytes.
+func randomURLSafeString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// codeChallengeS256 computes the RFC 7636 S256 code_challenge for verifier.
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// handleAuthStart issues a state parameter and PKCE verifier for a login
+// against the given provider, storing both server-side so the frontend
+// never has to see or forward the verifier.
+func handleAuthStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	providerName, ok := providerFromPath(r.URL.Path, "/start")
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+	if _, ok := providers.Get(providerName); !ok {
+		http.Error(w, "Unknown provider", http.StatusNotFound)
+		return
+	}
+
+	state, err := randomURLSafeString(32)
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to generate state: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	codeVerifier, err := randomURLSafeString(32)
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to generate code_verifier: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomURLSafeString(16)
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to generate nonce: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+	sessionID, err := randomURLSafeString(32)
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to generate oauth session ID: %v", err)
+		http.Error(w, "Server error", http.StatusInternalServerError)
+		return
+	}
+
+	pkceSessionsMu.Lock()
+	pkceSessions[sessionID] = &pkceSession{
+		provider:     providerName,
+		state:        state,
+		codeVerifier: codeVerifier,
+		nonce:        nonce,
+		expiresAt:    time.Now().Add(pkceSessionTTL),
+	}
+	pkceSessionsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthSessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(pkceSessionTTL / time.Second),
+	})
+
+	log.Printf("[AUTH LOG] auth_start provider=%s", providerName)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthStartResponse{
+		State:               state,
+		CodeChallenge:       codeChallengeS256(codeVerifier),
+		CodeChallengeMethod: "S256",
+		Nonce:               nonce,
+	})
+}
+
+// consumePKCESession looks up and deletes (single-use) the PKCE session for
+// the given oauth_session cookie, verifying it belongs to providerName and
+// hasn't expired.
+func consumePKCESession(r *http.Request, providerName string) (*pkceSession, error) {
+	cookie, err := r.Cookie(oauthSessionCookieName)
+	if err != nil {
+		return nil, errNoPKCESession
+	}
+
+	pkceSessionsMu.Lock()
+	session, ok := pkceSessions[cookie.Value]
+	if ok {
+		delete(pkceSessions, cookie.Value)
+	}
+	pkceSessionsMu.Unlock()
+
+	if !ok {
+		return nil, errNoPKCESession
+	}
+	if time.Now().After(session.expiresAt) {
+		return nil, errExpiredPKCESession
+	}
+	if session.provider != providerName {
+		return nil, errProviderMismatch
+	}
+	return session, nil
+}
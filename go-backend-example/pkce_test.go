@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestCodeChallengeS256 checks against the RFC 7636 Appendix B test vector.
+func TestCodeChallengeS256(t *testing.T) {
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const want = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got := codeChallengeS256(verifier); got != want {
+		t.Errorf("codeChallengeS256() = %q, want %q", got, want)
+	}
+}
+
+func TestHandleAuthStart(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/google/start", nil)
+	w := httptest.NewRecorder()
+
+	handleAuthStart(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	var resp AuthStartResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.State == "" || resp.CodeChallenge == "" || resp.Nonce == "" {
+		t.Fatalf("response missing fields: %+v", resp)
+	}
+	if resp.CodeChallengeMethod != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", resp.CodeChallengeMethod)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oauthSessionCookieName {
+		t.Fatalf("expected one %s cookie, got %+v", oauthSessionCookieName, cookies)
+	}
+
+	pkceSessionsMu.Lock()
+	session, ok := pkceSessions[cookies[0].Value]
+	pkceSessionsMu.Unlock()
+	if !ok {
+		t.Fatal("no pkceSession stored under the issued cookie")
+	}
+	if session.state != resp.State || session.nonce != resp.Nonce {
+		t.Errorf("stored session doesn't match response: %+v vs %+v", session, resp)
+	}
+	if codeChallengeS256(session.codeVerifier) != resp.CodeChallenge {
+		t.Error("returned code_challenge doesn't match the stored verifier")
+	}
+}
+
+func newPKCECookieRequest(t *testing.T, sessionID string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/google/callback", nil)
+	req.AddCookie(&http.Cookie{Name: oauthSessionCookieName, Value: sessionID})
+	return req
+}
+
+// TestConsumePKCESession_SingleUse verifies a session can be redeemed
+// exactly once, closing the replay hole a stolen code would otherwise open.
+func TestConsumePKCESession_SingleUse(t *testing.T) {
+	sessionID := "single-use-session"
+	pkceSessionsMu.Lock()
+	pkceSessions[sessionID] = &pkceSession{
+		provider:     "google",
+		state:        "expected-state",
+		codeVerifier: "verifier",
+		nonce:        "nonce",
+		expiresAt:    time.Now().Add(time.Minute),
+	}
+	pkceSessionsMu.Unlock()
+
+	req := newPKCECookieRequest(t, sessionID)
+	got, err := consumePKCESession(req, "google")
+	if err != nil {
+		t.Fatalf("first consume: %v", err)
+	}
+	if got.state != "expected-state" {
+		t.Errorf("state = %q, want expected-state", got.state)
+	}
+
+	req2 := newPKCECookieRequest(t, sessionID)
+	if _, err := consumePKCESession(req2, "google"); err != errNoPKCESession {
+		t.Errorf("second consume err = %v, want errNoPKCESession", err)
+	}
+}
+
+func TestConsumePKCESession_ProviderMismatch(t *testing.T) {
+	sessionID := "mismatch-session"
+	pkceSessionsMu.Lock()
+	pkceSessions[sessionID] = &pkceSession{
+		provider:  "google",
+		expiresAt: time.Now().Add(time.Minute),
+	}
+	pkceSessionsMu.Unlock()
+
+	req := newPKCECookieRequest(t, sessionID)
+	if _, err := consumePKCESession(req, "github"); err != errProviderMismatch {
+		t.Errorf("err = %v, want errProviderMismatch", err)
+	}
+}
+
+func TestConsumePKCESession_Expired(t *testing.T) {
+	sessionID := "expired-session"
+	pkceSessionsMu.Lock()
+	pkceSessions[sessionID] = &pkceSession{
+		provider:  "google",
+		expiresAt: time.Now().Add(-time.Minute),
+	}
+	pkceSessionsMu.Unlock()
+
+	req := newPKCECookieRequest(t, sessionID)
+	if _, err := consumePKCESession(req, "google"); err != errExpiredPKCESession {
+		t.Errorf("err = %v, want errExpiredPKCESession", err)
+	}
+}
+
+func TestConsumePKCESession_NoCookie(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/google/callback", nil)
+	if _, err := consumePKCESession(req, "google"); err != errNoPKCESession {
+		t.Errorf("err = %v, want errNoPKCESession", err)
+	}
+}
+
+func TestSweepExpiredPKCESessions(t *testing.T) {
+	pkceSessionsMu.Lock()
+	pkceSessions = map[string]*pkceSession{
+		"expired": {expiresAt: time.Now().Add(-time.Minute)},
+		"fresh":   {expiresAt: time.Now().Add(time.Hour)},
+	}
+	pkceSessionsMu.Unlock()
+
+	sweepExpiredPKCESessions(time.Now())
+
+	pkceSessionsMu.Lock()
+	defer pkceSessionsMu.Unlock()
+	if _, ok := pkceSessions["expired"]; ok {
+		t.Error("expired session was not swept")
+	}
+	if _, ok := pkceSessions["fresh"]; !ok {
+		t.Error("fresh session was incorrectly swept")
+	}
+}
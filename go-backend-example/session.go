@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "servio_session"
+
+// tokenStore is the process-wide TokenStore. It defaults to an in-memory
+// store; set TOKEN_STORE_SQLITE_PATH (together with a valid
+// TOKEN_STORE_ENCRYPTION_KEY) to persist sessions across restarts.
+var tokenStore TokenStore = newDefaultTokenStore()
+
+func newDefaultTokenStore() TokenStore {
+	path := os.Getenv("TOKEN_STORE_SQLITE_PATH")
+	if path == "" {
+		return NewMemoryTokenStore()
+	}
+	key, err := tokenEncryptionKey()
+	if err != nil {
+		log.Printf("[AUTH LOG] falling back to in-memory token store: %v", err)
+		return NewMemoryTokenStore()
+	}
+	store, err := NewSQLiteTokenStore(path, key)
+	if err != nil {
+		log.Printf("[AUTH LOG] failed to open sqlite token store, falling back to in-memory: %v", err)
+		return NewMemoryTokenStore()
+	}
+	return store
+}
+
+// newSessionID returns a random, URL-safe session identifier.
+func newSessionID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// setSessionCookie writes the HttpOnly session cookie for sessionID.
+func setSessionCookie(w http.ResponseWriter, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie deletes the session cookie from the browser.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// sessionFromRequest reads the session cookie and looks up its stored token.
+func sessionFromRequest(r *http.Request) (string, *StoredToken, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", nil, err
+	}
+	tok, err := tokenStore.Get(cookie.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	return cookie.Value, tok, nil
+}
+
+// handleSession returns the claims from the cached id_token for the caller's
+// session, without requiring the browser to hold any token material.
+func handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, tok, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := decodeIDTokenClaims(tok.IDToken)
+	if err != nil {
+		log.Printf("[AUTH LOG] failed to decode cached id_token: %v", err)
+		http.Error(w, "Session invalid", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+// handleRefresh rotates the stored access/refresh token pair using the
+// provider's token endpoint.
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, tok, err := sessionFromRequest(r)
+	if err != nil {
+		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	if tok.RefreshToken == "" {
+		log.Printf("[AUTH LOG] refresh requested but no refresh_token stored for provider=%s", tok.Provider)
+		http.Error(w, "No refresh token available", http.StatusBadRequest)
+		return
+	}
+
+	provider, ok := providers.Get(tok.Provider)
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusInternalServerError)
+		return
+	}
+	config := getOAuthConfig(tok.Provider)
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", tok.RefreshToken)
+	data.Set("client_id", config.ClientID)
+	if config.ClientSecret != "" {
+		data.Set("client_secret", config.ClientSecret)
+	}
+
+	req, err := http.NewRequest("POST", provider.TokenURL(), strings.NewReader(data.Encode()))
+	if err != nil {
+		log.Printf("[AUTH LOG] Failed to create refresh request: %v", err)
+		http.Error(w, "Refresh failed", http.StatusBadGateway)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("[AUTH LOG] Refresh request failed: %v", err)
+		http.Error(w, "Refresh failed", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	var refreshed GoogleTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		log.Printf("[AUTH LOG] Failed to decode refresh response: %v", err)
+		http.Error(w, "Refresh failed", http.StatusBadGateway)
+		return
+	}
+	if refreshed.Error != "" {
+		log.Printf("[AUTH LOG] Refresh error from provider=%s: %s", tok.Provider, refreshed.Error)
+		http.Error(w, "Refresh failed", http.StatusUnauthorized)
+		return
+	}
+
+	// Some providers don't reissue a refresh_token on rotation; keep the
+	// previous one in that case rather than discarding it.
+	newRefreshToken := refreshed.RefreshToken
+	if newRefreshToken == "" {
+		newRefreshToken = tok.RefreshToken
+	}
+	idToken := refreshed.IDToken
+	if idToken == "" {
+		idToken = tok.IDToken
+	}
+
+	updated := &StoredToken{
+		Provider:     tok.Provider,
+		AccessToken:  refreshed.AccessToken,
+		RefreshToken: newRefreshToken,
+		IDToken:      idToken,
+		ExpiresAt:    time.Now().Add(time.Duration(refreshed.ExpiresIn) * time.Second),
+	}
+	if err := tokenStore.Save(sessionID, updated); err != nil {
+		log.Printf("[AUTH LOG] Failed to persist refreshed session: %v", err)
+		http.Error(w, "Refresh failed", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("[AUTH LOG] session refreshed provider=%s", tok.Provider)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLogout revokes the stored token with the provider (if it exposes a
+// revoke endpoint) and deletes the session.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID, tok, err := sessionFromRequest(r)
+	if err != nil {
+		clearSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if provider, ok := providers.Get(tok.Provider); ok && provider.RevokeURL() != "" {
+		data := url.Values{}
+		data.Set("token", tok.AccessToken)
+		req, err := http.NewRequest("POST", provider.RevokeURL(), strings.NewReader(data.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			client := &http.Client{Timeout: 10 * time.Second}
+			if resp, err := client.Do(req); err != nil {
+				log.Printf("[AUTH LOG] revoke request failed provider=%s: %v", tok.Provider, err)
+			} else {
+				resp.Body.Close()
+			}
+		}
+	}
+
+	if err := tokenStore.Delete(sessionID); err != nil {
+		log.Printf("[AUTH LOG] Failed to delete session: %v", err)
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeIDTokenClaims decodes the payload segment of a JWT without
+// verifying its signature. It exists so /api/auth/session has something to
+// return; verification happens in finishLogin via the idtoken package (for
+// providers that publish a JWKS) before a login's tokens are first stored.
+// An id_token returned by handleRefresh is stored without being re-verified.
+func decodeIDTokenClaims(idToken string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// StoredToken is the token material kept server-side for a session. The
+// browser never sees these values directly; it only holds the session ID.
+type StoredToken struct {
+	Provider     string
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	ExpiresAt    time.Time
+}
+
+// TokenStore persists StoredToken values keyed by opaque session ID.
+type TokenStore interface {
+	Save(sessionID string, tok *StoredToken) error
+	Get(sessionID string) (*StoredToken, error)
+	Delete(sessionID string) error
+}
+
+// tokenEncryptionKey loads the AES-256 key used to encrypt token material
+// at rest from TOKEN_STORE_ENCRYPTION_KEY (32 bytes, hex-encoded).
+func tokenEncryptionKey() ([]byte, error) {
+	raw := os.Getenv("TOKEN_STORE_ENCRYPTION_KEY")
+	if raw == "" {
+		return nil, errors.New("TOKEN_STORE_ENCRYPTION_KEY not set")
+	}
+	key, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOKEN_STORE_ENCRYPTION_KEY: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("TOKEN_STORE_ENCRYPTION_KEY must decode to 32 bytes")
+	}
+	return key, nil
+}
+
+// encryptValue encrypts plaintext with AES-GCM and returns it base64-encoded
+// as nonce||ciphertext.
+func encryptValue(key []byte, plaintext string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptValue reverses encryptValue.
+func decryptValue(key []byte, encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// MemoryTokenStore is an in-process TokenStore, useful for local dev and
+// single-instance deployments.
+type MemoryTokenStore struct {
+	mu   sync.Mutex
+	data map[string]*StoredToken
+}
+
+// NewMemoryTokenStore returns an empty in-memory TokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{data: make(map[string]*StoredToken)}
+}
+
+func (s *MemoryTokenStore) Save(sessionID string, tok *StoredToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[sessionID] = tok
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(sessionID string) (*StoredToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, ok := s.data[sessionID]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	return tok, nil
+}
+
+func (s *MemoryTokenStore) Delete(sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, sessionID)
+	return nil
+}
+
+// SQLiteTokenStore persists encrypted token material in a SQLite database,
+// for deployments that need the token store to survive a process restart.
+type SQLiteTokenStore struct {
+	db  *sql.DB
+	key []byte
+}
+
+// NewSQLiteTokenStore opens (and migrates) a SQLite-backed TokenStore at path.
+func NewSQLiteTokenStore(path string, key []byte) (*SQLiteTokenStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		session_id TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		access_token TEXT NOT NULL,
+		refresh_token TEXT NOT NULL,
+		id_token TEXT NOT NULL,
+		expires_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteTokenStore{db: db, key: key}, nil
+}
+
+func (s *SQLiteTokenStore) Save(sessionID string, tok *StoredToken) error {
+	accessEnc, err := encryptValue(s.key, tok.AccessToken)
+	if err != nil {
+		return err
+	}
+	refreshEnc, err := encryptValue(s.key, tok.RefreshToken)
+	if err != nil {
+		return err
+	}
+	idEnc, err := encryptValue(s.key, tok.IDToken)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO sessions (session_id, provider, access_token, refresh_token, id_token, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(session_id) DO UPDATE SET
+			provider=excluded.provider,
+			access_token=excluded.access_token,
+			refresh_token=excluded.refresh_token,
+			id_token=excluded.id_token,
+			expires_at=excluded.expires_at`,
+		sessionID, tok.Provider, accessEnc, refreshEnc, idEnc, tok.ExpiresAt.Unix())
+	return err
+}
+
+func (s *SQLiteTokenStore) Get(sessionID string) (*StoredToken, error) {
+	var provider, accessEnc, refreshEnc, idEnc string
+	var expiresAt int64
+	row := s.db.QueryRow(`SELECT provider, access_token, refresh_token, id_token, expires_at
+		FROM sessions WHERE session_id = ?`, sessionID)
+	if err := row.Scan(&provider, &accessEnc, &refreshEnc, &idEnc, &expiresAt); err != nil {
+		return nil, err
+	}
+	accessToken, err := decryptValue(s.key, accessEnc)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := decryptValue(s.key, refreshEnc)
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := decryptValue(s.key, idEnc)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredToken{
+		Provider:     provider,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+		ExpiresAt:    time.Unix(expiresAt, 0),
+	}, nil
+}
+
+func (s *SQLiteTokenStore) Delete(sessionID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE session_id = ?`, sessionID)
+	return err
+}